@@ -0,0 +1,54 @@
+package alertmanager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resolvedAuth is the effective HTTP auth to use for a single request, after
+// resolving any HandlerConfig overrides against the service-wide defaults.
+type resolvedAuth struct {
+	BasicAuthUsername string `json:"basic_auth_username,omitempty"`
+	BasicAuthPassword string `json:"basic_auth_password,omitempty"`
+	BearerToken       string `json:"bearer_token,omitempty"`
+}
+
+// resolveAuth determines the effective basic-auth/bearer-token credentials for
+// a handler, falling back to the service-wide config when the handler does
+// not override them. BasicAuth takes precedence over a bearer token.
+func resolveAuth(sc Config, c HandlerConfig) (resolvedAuth, error) {
+	user, pass := c.BasicAuthUsername, c.BasicAuthPassword
+	if user == "" && pass == "" {
+		user, pass = sc.BasicAuthUsername, sc.BasicAuthPassword
+	}
+	if user != "" || pass != "" {
+		return resolvedAuth{BasicAuthUsername: user, BasicAuthPassword: pass}, nil
+	}
+
+	token, tokenFile := c.BearerToken, c.BearerTokenFile
+	if token == "" && tokenFile == "" {
+		token, tokenFile = sc.BearerToken, sc.BearerTokenFile
+	}
+	if token == "" && tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return resolvedAuth{}, errors.Wrapf(err, "failed to read bearer-token-file %q", tokenFile)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	return resolvedAuth{BearerToken: token}, nil
+}
+
+// apply sets the Authorization header or basic auth on req.
+func (a resolvedAuth) apply(req *http.Request) {
+	if a.BasicAuthUsername != "" || a.BasicAuthPassword != "" {
+		req.SetBasicAuth(a.BasicAuthUsername, a.BasicAuthPassword)
+		return
+	}
+	if a.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+a.BearerToken)
+	}
+}
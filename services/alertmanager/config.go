@@ -1,6 +1,9 @@
 package alertmanager
 
 import (
+	"time"
+
+	"github.com/influxdata/influxdb/toml"
 	"github.com/pkg/errors"
 	"net/url"
 	"os"
@@ -10,23 +13,112 @@ import (
 type Config struct {
 	// Wheather the service should be enabled
 	Enabled bool `toml:"enabled" override:"enabled"`
-	// URL of the alertmanager endpoint
+	// URL of the alertmanager endpoint.
+	// Deprecated: use URLs instead. If both are set, URL is added to URLs.
 	URL string `toml:"url" override:"url"`
+	// URLs of an AlertManager cluster. Alerts are sent to every URL concurrently
+	// so that grouping/dedup keeps working if one peer is down.
+	URLs []string `toml:"urls" override:"urls"`
+	// KapacitorURL is the externally reachable URL of this Kapacitor instance.
+	// It is used to populate the generatorURL field of alerts sent to AlertManager
+	// so that the AlertManager UI can link back to the task that generated the alert.
+	KapacitorURL string `toml:"kapacitor-url" override:"kapacitor-url"`
+	// Version of the AlertManager HTTP API to speak, either "v1" or "v2".
+	// Defaults to "v1" to preserve existing behavior.
+	Version string `toml:"version" override:"version"`
 	// Retry folder
 	RetryFolder string `toml:"retry-folder" override:"retry-folder"`
+	// RetryInitialInterval is the backoff interval used for the first retry
+	// of a failed alert post.
+	RetryInitialInterval toml.Duration `toml:"retry-initial-interval" override:"retry-initial-interval"`
+	// RetryMaxInterval caps the exponential backoff between retry attempts.
+	RetryMaxInterval toml.Duration `toml:"retry-max-interval" override:"retry-max-interval"`
+	// RetryMaxAttempts is the number of times a failed alert post is retried
+	// before the spooled file is dropped. Zero means retry forever.
+	RetryMaxAttempts int `toml:"retry-max-attempts" override:"retry-max-attempts"`
+
+	// Timeout is the per-request timeout for posting an alert to AlertManager.
+	Timeout toml.Duration `toml:"timeout" override:"timeout"`
+	// MaxIdleConns is the maximum number of idle (keep-alive) connections kept
+	// open to AlertManager endpoints.
+	MaxIdleConns int `toml:"max-idle-conns" override:"max-idle-conns"`
+	// IdleConnTimeout is how long an idle connection is kept open before being closed.
+	IdleConnTimeout toml.Duration `toml:"idle-conn-timeout" override:"idle-conn-timeout"`
+	// InsecureSkipVerify disables TLS certificate verification. Use with caution.
+	InsecureSkipVerify bool `toml:"insecure-skip-verify" override:"insecure-skip-verify"`
+	// CACerts is a list of paths to PEM encoded CA certificate files used to
+	// verify the AlertManager server certificate, in addition to the system pool.
+	CACerts []string `toml:"ca-certs" override:"ca-certs"`
+	// ClientCert and ClientKey, if both set, are used for mutual TLS to AlertManager.
+	ClientCert string `toml:"client-cert" override:"client-cert"`
+	ClientKey  string `toml:"client-key" override:"client-key"`
+	// HTTPProxy is the URL of an HTTP proxy to use when talking to AlertManager.
+	// If empty, the standard HTTP_PROXY/HTTPS_PROXY environment variables are used.
+	HTTPProxy string `toml:"http-proxy" override:"http-proxy"`
+
+	// BasicAuthUsername and BasicAuthPassword configure HTTP basic auth, matching
+	// AlertManager's own http_config vocabulary. HandlerConfig may override these per task.
+	BasicAuthUsername string `toml:"basic-auth-username" override:"basic-auth-username"`
+	BasicAuthPassword string `toml:"basic-auth-password" override:"basic-auth-password,redact"`
+	// BearerToken and BearerTokenFile configure bearer token auth. BearerToken takes
+	// precedence over BearerTokenFile when both are set.
+	BearerToken     string `toml:"bearer-token" override:"bearer-token,redact"`
+	BearerTokenFile string `toml:"bearer-token-file" override:"bearer-token-file"`
+}
+
+// urlList returns the de-duplicated set of AlertManager URLs to send alerts
+// to, combining the deprecated single URL field with URLs.
+func (c Config) urlList() []string {
+	return mergeURLs(c.URL, c.URLs)
+}
+
+func mergeURLs(single string, multi []string) []string {
+	seen := make(map[string]bool, len(multi)+1)
+	var urls []string
+	add := func(u string) {
+		if u == "" || seen[u] {
+			return
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	add(single)
+	for _, u := range multi {
+		add(u)
+	}
+	return urls
 }
 
 func NewConfig() Config {
-	return Config{}
+	return Config{
+		Version:              "v1",
+		RetryInitialInterval: toml.Duration(500 * time.Millisecond),
+		RetryMaxInterval:     toml.Duration(5 * time.Minute),
+		RetryMaxAttempts:     10,
+		Timeout:              toml.Duration(30 * time.Second),
+		MaxIdleConns:         10,
+		IdleConnTimeout:      toml.Duration(90 * time.Second),
+	}
 }
 
 func (c Config) Validate() error {
 	if c.Enabled {
-		if c.URL == "" {
+		urls := c.urlList()
+		if len(urls) == 0 {
 			return errors.New("url cannot be empty")
 		}
-		if _, err := url.Parse(c.URL); err != nil {
-			return errors.Wrapf(err, "invalid AlertManager URL: %q", c.URL)
+		for _, u := range urls {
+			if _, err := url.Parse(u); err != nil {
+				return errors.Wrapf(err, "invalid AlertManager URL: %q", u)
+			}
+		}
+		switch c.Version {
+		case "", "v1", "v2":
+		default:
+			return errors.Errorf("unknown AlertManager API version %q, must be \"v1\" or \"v2\"", c.Version)
+		}
+		if (c.ClientCert == "") != (c.ClientKey == "") {
+			return errors.New("client-cert and client-key must both be set to use client TLS auth")
 		}
 	}
 	if _, err := os.Stat(c.RetryFolder); os.IsNotExist(err) {
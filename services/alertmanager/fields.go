@@ -0,0 +1,54 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// fieldFormatJSON, when set as HandlerConfig.FieldFormat, preserves the
+// structure of non-scalar field values by JSON encoding every field rather
+// than formatting scalars as plain strings.
+const fieldFormatJSON = "json"
+
+// formatFieldValue renders a TICKscript field value as the string used for an
+// AlertManager annotation. AlertManager annotations are always strings, but
+// event.Data.Fields commonly holds ints, floats, bools, times, or nested
+// slices/maps, so a plain type assertion to string panics for anything but
+// the string case.
+func formatFieldValue(v interface{}, format string) string {
+	if format == fieldFormatJSON {
+		return marshalField(v)
+	}
+	switch value := v.(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case int:
+		return strconv.Itoa(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339)
+	case time.Duration:
+		return value.String()
+	default:
+		// slices, maps, and anything else unforeseen fall back to JSON so the
+		// annotation is still a faithful, stable representation of the value.
+		return marshalField(v)
+	}
+}
+
+// marshalField JSON encodes v, falling back to fmt.Sprintf if it cannot be
+// marshaled so that a single malformed field never drops the whole alert.
+func marshalField(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
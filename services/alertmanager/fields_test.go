@@ -0,0 +1,64 @@
+package alertmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatFieldValue_String(t *testing.T) {
+	got := formatFieldValue("hello", "")
+	if got != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestFormatFieldValue_Int(t *testing.T) {
+	got := formatFieldValue(int64(42), "")
+	if got != "42" {
+		t.Errorf("got %q, want %q", got, "42")
+	}
+}
+
+func TestFormatFieldValue_Float(t *testing.T) {
+	got := formatFieldValue(3.14, "")
+	if got != "3.14" {
+		t.Errorf("got %q, want %q", got, "3.14")
+	}
+}
+
+func TestFormatFieldValue_Bool(t *testing.T) {
+	got := formatFieldValue(true, "")
+	if got != "true" {
+		t.Errorf("got %q, want %q", got, "true")
+	}
+}
+
+func TestFormatFieldValue_Time(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := formatFieldValue(ts, "")
+	want := ts.Format(time.RFC3339)
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatFieldValue_Nested(t *testing.T) {
+	v := map[string]interface{}{"a": 1.0, "b": "c"}
+	got := formatFieldValue(v, "")
+	want := `{"a":1,"b":"c"}`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatFieldValue_JSONFormat(t *testing.T) {
+	// With field-format=json, even scalars are JSON encoded.
+	got := formatFieldValue(int64(42), fieldFormatJSON)
+	if got != "42" {
+		t.Errorf("got %q, want %q", got, "42")
+	}
+	got = formatFieldValue("hello", fieldFormatJSON)
+	if got != `"hello"` {
+		t.Errorf("got %q, want %q", got, `"hello"`)
+	}
+}
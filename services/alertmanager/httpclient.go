@@ -0,0 +1,78 @@
+package alertmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newHTTPClient builds the long-lived *http.Client used for all requests to
+// AlertManager, configured from c. It is rebuilt whenever the config changes
+// via Update so that TLS/proxy settings can be updated without a restart.
+func newHTTPClient(c Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConns,
+		IdleConnTimeout:     time.Duration(c.IdleConnTimeout),
+		Proxy:               http.ProxyFromEnvironment,
+	}
+	if c.HTTPProxy != "" {
+		proxyURL, err := url.Parse(c.HTTPProxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid http-proxy %q", c.HTTPProxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// newTLSConfig builds the *tls.Config used to talk to AlertManager, loading
+// any configured CA certificates and client certificate/key pair.
+func newTLSConfig(c Config) (*tls.Config, error) {
+	if !c.InsecureSkipVerify && len(c.CACerts) == 0 && c.ClientCert == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if len(c.CACerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caCert := range c.CACerts {
+			pem, err := ioutil.ReadFile(caCert)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read ca-cert %q", caCert)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.Errorf("failed to parse ca-cert %q", caCert)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" && c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client-cert/client-key")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
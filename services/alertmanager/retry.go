@@ -0,0 +1,237 @@
+package alertmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// retryScanInterval is how often the retry folder is scanned for spooled alerts.
+const retryScanInterval = 10 * time.Second
+
+// retryFileSuffix identifies spooled alert files in the retry folder.
+const retryFileSuffix = ".retry.json"
+
+// retryRecord is the on-disk representation of a single spooled alert.
+// It doubles as both the saved POST body and the sidecar bookkeeping the
+// background retry loop needs to resume correctly across restarts.
+//
+// retryRecord deliberately does not persist basic-auth/bearer-token
+// credentials: the Config fields they come from are marked "redact" because
+// they're secrets, and a spool file can sit on disk indefinitely (forever,
+// if retry-max-attempts is 0), so writing them here would be a plaintext
+// secret leak independent of that protection. Retries instead re-resolve
+// auth from the live service-wide Config at send time (see retryOne), which
+// means a HandlerConfig-level auth override only applies to the original,
+// live delivery attempt, not to retries of it.
+type retryRecord struct {
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// saveJSON spools a failed alert post to retryFolder so the background retry
+// loop started in Open can re-send it later. Spool files are keyed by
+// (url, fingerprint): if this exact alert is already queued for this peer,
+// its Payload/URL are updated to the latest data so a later update (most
+// importantly an OK event resolving a previously-firing alert) is the one
+// that eventually gets delivered, while Attempts/NextAttempt are left
+// untouched so a fast-failing peer doesn't reset its own backoff schedule,
+// and so that one slow/unreachable peer never affects the spool of any
+// other peer.
+//
+// retryFolder is recorded in s.retryFolders so that drainRetryFolder also
+// scans it even when it differs from the service-wide default, e.g. a task
+// using a HandlerConfig.RetryFolder override.
+func (s *Service) saveJSON(retryFolder, url, fingerprint string, data []byte) error {
+	s.retryFolders.Store(retryFolder, struct{}{})
+
+	outFile := filepath.Join(retryFolder, retryFileName(url, fingerprint))
+	rec := retryRecord{URL: url, Payload: data}
+	if existing, err := ioutil.ReadFile(outFile); err == nil {
+		var prev retryRecord
+		if err := json.Unmarshal(existing, &prev); err == nil {
+			rec.Attempts = prev.Attempts
+			rec.NextAttempt = prev.NextAttempt
+		}
+	} else {
+		c := s.config()
+		rec.NextAttempt = time.Now().Add(time.Duration(c.RetryInitialInterval))
+	}
+	return writeRetryRecordFile(outFile, rec)
+}
+
+// retryFileName derives a stable spool filename from the (url, fingerprint)
+// pair so repeated failures for the same alert/peer combination update a
+// single file rather than growing the spool unbounded.
+func retryFileName(url, fingerprint string) string {
+	sum := sha256.Sum256([]byte(url + "|" + fingerprint))
+	return hex.EncodeToString(sum[:]) + retryFileSuffix
+}
+
+// writeRetryRecordFile marshals and writes rec, overwriting any existing
+// sidecar at path. It is used both for the initial spool and for updating
+// attempt count / next-attempt time after a failed retry.
+func writeRetryRecordFile(path string, rec retryRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0640)
+}
+
+// runRetryLoop periodically drains the retry folder until ctx is cancelled.
+// It mirrors the suture v4 Serve(ctx) convention: Close cancels ctx and waits
+// for this goroutine to return.
+func (s *Service) runRetryLoop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(retryScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.drainRetryFolder()
+		}
+	}
+}
+
+// drainRetryFolder scans every retry folder in use — the service-wide
+// default plus every folder a HandlerConfig.RetryFolder override has ever
+// spooled into (tracked in s.retryFolders) — and attempts to resend any
+// spooled alert whose next-attempt time has passed.
+//
+// A folder that only a task's override writes to is not known until a failed
+// delivery in this process registers it via saveJSON, so after a restart any
+// alerts left in such a folder are not scanned until a delivery through that
+// override fails again.
+func (s *Service) drainRetryFolder() {
+	c := s.config()
+	folders := make(map[string]bool)
+	if c.RetryFolder != "" {
+		folders[c.RetryFolder] = true
+	}
+	s.retryFolders.Range(func(folder, _ interface{}) bool {
+		folders[folder.(string)] = true
+		return true
+	})
+	if len(folders) == 0 {
+		return
+	}
+
+	depth := 0
+	now := time.Now()
+	for folder := range folders {
+		depth += s.drainFolder(c, folder, now)
+	}
+	s.diag.RetryQueueDepth(depth)
+}
+
+// drainFolder scans a single retry folder and returns the number of spooled
+// alerts found in it, whether or not they were due for a retry attempt.
+func (s *Service) drainFolder(c Config, folder string, now time.Time) int {
+	entries, err := ioutil.ReadDir(folder)
+	if err != nil {
+		s.diag.Error("failed to scan AlertManager retry folder", err)
+		return 0
+	}
+
+	depth := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), retryFileSuffix) {
+			continue
+		}
+		depth++
+		path := filepath.Join(folder, entry.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			s.diag.Error("failed to read spooled AlertManager alert", err)
+			continue
+		}
+		var rec retryRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			s.diag.Error("failed to decode spooled AlertManager alert", err)
+			continue
+		}
+		if rec.NextAttempt.After(now) {
+			continue
+		}
+		s.retryOne(c, path, entry.Name(), rec)
+	}
+	return depth
+}
+
+// retryOne attempts to resend a single spooled alert, updating or removing
+// its on-disk record depending on the outcome. Auth is re-resolved from the
+// live service-wide config rather than read from rec, since retryRecord does
+// not persist credentials (see retryRecord's doc comment); a HandlerConfig
+// auth override in effect for the original delivery is not available here
+// and so is not applied to retries.
+func (s *Service) retryOne(c Config, path, name string, rec retryRecord) {
+	s.diag.RetryAttempt(name, rec.Attempts+1)
+
+	auth, err := resolveAuth(c, HandlerConfig{})
+	if err != nil {
+		s.diag.Error("failed to resolve AlertManager auth for retry", err)
+		return
+	}
+
+	statusCode, err := s.post(c, auth, rec.URL, rec.Payload)
+	if err == nil && statusCode/100 == 2 {
+		if rmErr := os.Remove(path); rmErr != nil {
+			s.diag.Error("failed to remove spooled AlertManager alert", rmErr)
+		}
+		s.diag.RetrySuccess(name)
+		return
+	}
+
+	rec.Attempts++
+	if c.RetryMaxAttempts > 0 && rec.Attempts >= c.RetryMaxAttempts {
+		if rmErr := os.Remove(path); rmErr != nil {
+			s.diag.Error("failed to remove dropped AlertManager alert", rmErr)
+		}
+		s.diag.RetryDropped(name, rec.Attempts)
+		return
+	}
+
+	rec.NextAttempt = time.Now().Add(nextBackoff(c, rec.Attempts))
+	if writeErr := writeRetryRecordFile(path, rec); writeErr != nil {
+		s.diag.Error("failed to update spooled AlertManager alert", writeErr)
+	}
+}
+
+// nextBackoff computes the exponential backoff with jitter for the given
+// attempt count, capped at the configured maximum interval.
+func nextBackoff(c Config, attempt int) time.Duration {
+	initial := time.Duration(c.RetryInitialInterval)
+	max := time.Duration(c.RetryMaxInterval)
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 5 * time.Minute
+	}
+	interval := initial * time.Duration(1<<uint(attempt))
+	if interval <= 0 || interval > max {
+		interval = max
+	}
+	// add +/-50% jitter so retries from many files don't thunder in lockstep
+	jitter := time.Duration(rand.Int63n(int64(interval))) - interval/2
+	interval += jitter
+	if interval < 0 {
+		interval = initial
+	}
+	if interval > max {
+		interval = max
+	}
+	return interval
+}
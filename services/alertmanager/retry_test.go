@@ -0,0 +1,177 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+// fakeDiagnostic is a no-op Diagnostic used by tests that need a *Service but
+// don't care about the structured logging/telemetry it reports.
+type fakeDiagnostic struct{}
+
+func (fakeDiagnostic) WithContext(ctx ...keyvalue.T) Diagnostic { return fakeDiagnostic{} }
+func (fakeDiagnostic) TemplateError(err error, kv keyvalue.T)   {}
+func (fakeDiagnostic) Error(msg string, err error)              {}
+func (fakeDiagnostic) Debug(msg string)                         {}
+func (fakeDiagnostic) RetryAttempt(file string, attempt int)    {}
+func (fakeDiagnostic) RetrySuccess(file string)                 {}
+func (fakeDiagnostic) RetryDropped(file string, attempts int)   {}
+func (fakeDiagnostic) RetryQueueDepth(depth int)                {}
+
+func TestDrainRetryFolder_HandlerOverride(t *testing.T) {
+	defaultFolder := t.TempDir()
+	overrideFolder := t.TempDir()
+
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = server.URL
+	c.RetryFolder = defaultFolder
+	c.RetryInitialInterval = 0
+	s := NewService(c, fakeDiagnostic{})
+
+	// Simulate a failed post spooled via a HandlerConfig.RetryFolder override
+	// that differs from the service-wide default.
+	if err := s.saveJSON(overrideFolder, server.URL, "fingerprint-1", []byte(`[]`)); err != nil {
+		t.Fatalf("saveJSON failed: %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(overrideFolder)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one spooled file in the override folder, got %v (err %v)", entries, err)
+	}
+
+	s.drainRetryFolder()
+
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Fatalf("expected drainRetryFolder to also scan the handler override folder, got %d hits", got)
+	}
+	entries, err = ioutil.ReadDir(overrideFolder)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected spooled alert to be removed after a successful retry, got %d entries", len(entries))
+	}
+}
+
+func TestSaveJSON_DoesNotPersistAuthSecrets(t *testing.T) {
+	dir := t.TempDir()
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = "http://example.invalid"
+	c.RetryFolder = dir
+	c.BearerToken = "super-secret-token"
+	s := NewService(c, fakeDiagnostic{})
+
+	if err := s.saveJSON(dir, "http://example.invalid", "fp-1", []byte(`[]`)); err != nil {
+		t.Fatalf("saveJSON failed: %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one spooled file, got %v (err %v)", entries, err)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("failed to read spool file: %v", err)
+	}
+	if strings.Contains(string(raw), "auth") || strings.Contains(string(raw), "super-secret-token") {
+		t.Errorf("expected spooled retry record to contain no auth data, got %s", raw)
+	}
+}
+
+func TestRetryOne_ReResolvesAuthFromServiceConfig(t *testing.T) {
+	var gotAuthHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuthHeader = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = server.URL
+	c.RetryFolder = dir
+	c.BearerToken = "service-level-token"
+	s := NewService(c, fakeDiagnostic{})
+
+	rec := retryRecord{URL: server.URL, Payload: []byte(`[]`)}
+	path := filepath.Join(dir, "test"+retryFileSuffix)
+	if err := writeRetryRecordFile(path, rec); err != nil {
+		t.Fatalf("writeRetryRecordFile failed: %v", err)
+	}
+
+	s.retryOne(c, path, "test"+retryFileSuffix, rec)
+
+	if gotAuthHeader != "Bearer service-level-token" {
+		t.Errorf("expected retry to re-resolve the service-wide bearer token, got %q", gotAuthHeader)
+	}
+}
+
+func TestSaveJSON_OverwritesPayloadForExistingKey(t *testing.T) {
+	dir := t.TempDir()
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = "http://example.invalid"
+	c.RetryFolder = dir
+	s := NewService(c, fakeDiagnostic{})
+
+	url := "http://example.invalid"
+	fingerprint := "fp-1"
+	if err := s.saveJSON(dir, url, fingerprint, []byte(`"firing"`)); err != nil {
+		t.Fatalf("saveJSON failed: %v", err)
+	}
+
+	// Simulate a prior failed attempt so Attempts/NextAttempt are non-zero,
+	// then confirm a later update for the same (url, fingerprint) preserves
+	// that backoff state while replacing the stale payload.
+	path := filepath.Join(dir, retryFileName(url, fingerprint))
+	wantNextAttempt := time.Now().Add(time.Hour).Round(time.Second)
+	if err := writeRetryRecordFile(path, retryRecord{
+		URL:         url,
+		Payload:     []byte(`"firing"`),
+		Attempts:    3,
+		NextAttempt: wantNextAttempt,
+	}); err != nil {
+		t.Fatalf("writeRetryRecordFile failed: %v", err)
+	}
+
+	if err := s.saveJSON(dir, url, fingerprint, []byte(`"resolved"`)); err != nil {
+		t.Fatalf("saveJSON failed: %v", err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read spool file: %v", err)
+	}
+	var got retryRecord
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("failed to decode spool file: %v", err)
+	}
+	if string(got.Payload) != `"resolved"` {
+		t.Errorf("expected payload to be updated to the latest data, got %s", got.Payload)
+	}
+	if got.Attempts != 3 {
+		t.Errorf("expected Attempts to be preserved across a payload update, got %d", got.Attempts)
+	}
+	if !got.NextAttempt.Equal(wantNextAttempt) {
+		t.Errorf("expected NextAttempt to be preserved, got %v want %v", got.NextAttempt, wantNextAttempt)
+	}
+}
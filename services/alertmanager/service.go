@@ -2,23 +2,47 @@ package alertmanager
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/influxdata/kapacitor/alert"
 	"github.com/influxdata/kapacitor/keyvalue"
 	"github.com/pkg/errors"
-	"github.com/satori/go.uuid"
 )
 
+// resolvedRetention is how far in the past endsAt is set for a resolved (OK) alert.
+const resolvedRetention = time.Second
+
+// firingRetention is how far in the future endsAt is set for a firing (non-OK)
+// alert so that AlertManager does not auto-resolve it before the next update arrives.
+const firingRetention = 24 * time.Hour
+
 type Service struct {
 	configValue atomic.Value
+	clientValue atomic.Value
 	diag        Diagnostic
+
+	// retryFolders tracks every retry folder a spooled alert has ever been
+	// written to (keyed by folder path), since a HandlerConfig.RetryFolder
+	// override lets a task spool into a folder the service-wide config never
+	// mentions. drainRetryFolder scans the union of this set and the
+	// service-wide default so per-task override folders are scanned once a
+	// failed delivery in this process has registered them.
+	retryFolders sync.Map
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 type Diagnostic interface {
@@ -26,6 +50,17 @@ type Diagnostic interface {
 	TemplateError(err error, kv keyvalue.T)
 	Error(msg string, err error)
 	Debug(msg string)
+
+	// RetryAttempt is called each time the background retry loop re-posts a
+	// spooled alert, reporting the attempt number (1-indexed).
+	RetryAttempt(file string, attempt int)
+	// RetrySuccess is called when a spooled alert is successfully delivered.
+	RetrySuccess(file string)
+	// RetryDropped is called when a spooled alert is discarded after
+	// exhausting retry-max-attempts.
+	RetryDropped(file string, attempts int)
+	// RetryQueueDepth reports the current number of spooled alerts awaiting retry.
+	RetryQueueDepth(depth int)
 }
 
 func NewService(c Config, d Diagnostic) *Service {
@@ -33,17 +68,40 @@ func NewService(c Config, d Diagnostic) *Service {
 		diag: d,
 	}
 	s.configValue.Store(c)
+	client, err := newHTTPClient(c)
+	if err != nil {
+		d.Error("failed to configure AlertManager HTTP client, falling back to defaults", err)
+		client = http.DefaultClient
+	}
+	s.clientValue.Store(client)
 	return s
 }
 
+// client loads the *http.Client built from the current config.
+func (s *Service) client() *http.Client {
+	return s.clientValue.Load().(*http.Client)
+}
+
+// Open starts the background goroutine that drains the retry spool.
 func (s *Service) Open() error {
-	// Perform any initialization needed here
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go s.runRetryLoop(ctx)
 	return nil
 }
 
+// Close stops the background retry goroutine and waits for it to exit.
 func (s *Service) Close() error {
-	// Perform any actions needed to properly close the service here.
-	// For example signal and wait for all go routines to finish.
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
 	return nil
 }
 
@@ -54,7 +112,12 @@ func (s *Service) Update(newConfig []interface{}) error {
 	if c, ok := newConfig[0].(Config); !ok {
 		return fmt.Errorf("expected config object to be of type %T, got %T", c, newConfig[0])
 	} else {
+		client, err := newHTTPClient(c)
+		if err != nil {
+			return errors.Wrap(err, "failed to configure AlertManager HTTP client")
+		}
 		s.configValue.Store(c)
+		s.clientValue.Store(client)
 	}
 	return nil
 }
@@ -64,17 +127,24 @@ func (s *Service) config() Config {
 	return s.configValue.Load().(Config)
 }
 
-// Alert sends a message to the specified room.
-func (s *Service) Alert(url, retryFolder string, event alert.Event) error {
-	c := s.config()
-	if !c.Enabled {
+// alertManagerEvent is the payload sent to the AlertManager v1/v2 alerts endpoint.
+// StartsAt/EndsAt/GeneratorURL are only populated when talking to the v2 API.
+type alertManagerEvent struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     string            `json:"startsAt,omitempty"`
+	EndsAt       string            `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Alert sends event to the AlertManager endpoint(s) described by c.
+func (s *Service) Alert(c HandlerConfig, event alert.Event) error {
+	sc := s.config()
+	if !sc.Enabled {
 		return errors.New("service is not enabled")
 	}
-	type AlertManagerEvent struct {
-		Labels      map[string]string `json:"labels"`
-		Annotations map[string]string `json:"annotations"`
-	}
-	amEvent := AlertManagerEvent{
+
+	amEvent := alertManagerEvent{
 		Labels:      make(map[string]string),
 		Annotations: make(map[string]string),
 	}
@@ -93,48 +163,242 @@ func (s *Service) Alert(url, retryFolder string, event alert.Event) error {
 	for k, v := range event.Data.Tags {
 		amEvent.Labels[k] = v
 	}
+	// add static labels/annotations configured on the handler
+	for k, v := range c.StaticLabels {
+		amEvent.Labels[k] = v
+	}
+	for k, v := range c.StaticAnnotations {
+		amEvent.Annotations[k] = v
+	}
 
 	// add fields as annotations
 	for k, v := range event.Data.Fields {
-		amEvent.Annotations[k] = v.(string)
+		amEvent.Annotations[k] = formatFieldValue(v, c.FieldFormat)
 	}
 
-	data, err := json.Marshal([]AlertManagerEvent{amEvent})
+	for _, label := range c.GroupLabels {
+		if _, ok := amEvent.Labels[label]; !ok {
+			s.diag.WithContext(keyvalue.KV("label", label)).Debug(
+				"configured group-labels entry is not present on this alert; AlertManager route grouping may not behave as expected")
+		}
+	}
+
+	version := c.Version
+	if version == "" {
+		version = sc.Version
+	}
+	if version == "" {
+		version = "v1"
+	}
+	if version == "v2" {
+		firingWindow := firingRetention
+		if c.ResolveTimeout != "" {
+			d, err := time.ParseDuration(c.ResolveTimeout)
+			if err != nil {
+				return errors.Wrapf(err, "invalid resolve-timeout %q", c.ResolveTimeout)
+			}
+			firingWindow = d
+		}
+
+		now := time.Now()
+		if event.State.Level == alert.OK {
+			// Resolve the alert by moving endsAt into the past.
+			amEvent.StartsAt = now.Add(-firingRetention).Format(time.RFC3339)
+			amEvent.EndsAt = now.Add(-resolvedRetention).Format(time.RFC3339)
+		} else {
+			// Keep the alert firing until the next update arrives.
+			amEvent.StartsAt = now.Format(time.RFC3339)
+			amEvent.EndsAt = now.Add(firingWindow).Format(time.RFC3339)
+		}
+		amEvent.GeneratorURL = generatorURL(sc.KapacitorURL, event)
+	}
+
+	data, err := json.Marshal([]alertManagerEvent{amEvent})
 	if err != nil {
 		return err
 	}
-	r, err := http.Post(url, "application/json", bytes.NewReader(data))
+
+	auth, err := resolveAuth(sc, c)
 	if err != nil {
-		// write json for retry only it couldn't be posted
-		save_err := s.saveJSON(retryFolder, data)
-		if save_err != nil {
-			s.diag.Error("Couldn't save alert for retry", save_err)
-		}
 		return err
 	}
-	r.Body.Close()
-	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected response code %d from AlertManager service", r.StatusCode)
+
+	urls := c.urlList()
+	if len(urls) == 0 {
+		urls = sc.urlList()
 	}
-	return nil
+	if len(urls) == 0 {
+		return errors.New("no AlertManager urls configured")
+	}
+	if version == "v2" {
+		for i, u := range urls {
+			urls[i] = v2Endpoint(u)
+		}
+	}
+
+	fingerprint := event.State.ID
+
+	retryFolder := c.RetryFolder
+	if retryFolder == "" {
+		retryFolder = sc.RetryFolder
+	}
+
+	return s.sendAll(urls, fingerprint, retryFolder, auth, data)
 }
 
-func (s *Service) saveJSON(retryFolder string, json []byte) error {
-	file_id, uuid_err := uuid.NewV4()
-	if uuid_err != nil {
-		return uuid_err
+// sendAll posts data to every url concurrently, returning nil if at least one
+// peer accepted the alert. Peers that fail are spooled for retry independently,
+// keyed by (url, fingerprint), so a single slow peer does not cause the alert
+// to be re-delivered to peers that already succeeded.
+func (s *Service) sendAll(urls []string, fingerprint, retryFolder string, auth resolvedAuth, data []byte) error {
+	sc := s.config()
+	type result struct {
+		url        string
+		statusCode int
+		err        error
 	}
-	out_file := filepath.Join(retryFolder, file_id.String())
-	file_err := ioutil.WriteFile(out_file, json, 0640)
-	if file_err != nil {
-		return file_err
+	results := make(chan result, len(urls))
+	for _, u := range urls {
+		u := u
+		go func() {
+			statusCode, err := s.post(sc, auth, u, data)
+			results <- result{url: u, statusCode: statusCode, err: err}
+		}()
 	}
-	return nil
+
+	var anySucceeded bool
+	var lastErr error
+	for i := 0; i < len(urls); i++ {
+		r := <-results
+		diag := s.diag.WithContext(keyvalue.KV("url", r.url))
+		switch {
+		case r.err != nil:
+			diag.Error("failed to post alert to AlertManager peer", r.err)
+			lastErr = r.err
+			if saveErr := s.saveJSON(retryFolder, r.url, fingerprint, data); saveErr != nil {
+				diag.Error("couldn't save alert for retry", saveErr)
+			}
+		case r.statusCode/100 != 2:
+			err := fmt.Errorf("unexpected response code %d from AlertManager peer", r.statusCode)
+			diag.Error("failed to post alert to AlertManager peer", err)
+			lastErr = err
+			if saveErr := s.saveJSON(retryFolder, r.url, fingerprint, data); saveErr != nil {
+				diag.Error("couldn't save alert for retry", saveErr)
+			}
+		default:
+			anySucceeded = true
+		}
+	}
+
+	if anySucceeded {
+		return nil
+	}
+	return lastErr
+}
+
+// post sends data to url using the service's shared HTTP client, applying
+// auth and the configured per-request timeout.
+func (s *Service) post(sc Config, auth resolvedAuth, url string, data []byte) (int, error) {
+	timeout := time.Duration(sc.Timeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	auth.apply(req)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain the body to EOF so the underlying connection is returned to the
+	// client's idle pool instead of being closed; net/http only reuses a
+	// connection for keep-alive once the body has been fully read and closed.
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// generatorURL builds a link back to the task that generated the event so the
+// AlertManager UI can point operators at the source of the alert.
+func generatorURL(kapacitorURL string, event alert.Event) string {
+	if kapacitorURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/kapacitor/v1/tasks/%s", strings.TrimRight(kapacitorURL, "/"), event.Data.TaskName)
+}
+
+// v2Endpoint appends the v2 alerts API path to the configured AlertManager URL
+// unless it has already been included.
+func v2Endpoint(u string) string {
+	trimmed := strings.TrimRight(u, "/")
+	if strings.HasSuffix(trimmed, "/api/v2/alerts") {
+		return trimmed
+	}
+	return trimmed + "/api/v2/alerts"
 }
 
 type HandlerConfig struct {
-	URL         string `mapstructure:"url"`
-	RetryFolder string `mapstructure:"retry-folder"`
+	// URL of the alertmanager endpoint.
+	// Deprecated: use URLs instead. If both are set, URL is added to URLs.
+	URL string `mapstructure:"url"`
+	// URLs of an AlertManager cluster to fan this handler's alerts out to.
+	// Defaults to the service-wide URLs when unset.
+	URLs        []string `mapstructure:"urls"`
+	RetryFolder string   `mapstructure:"retry-folder"`
+	// Version of the AlertManager HTTP API to use for this handler, "v1" or "v2".
+	// Defaults to the service-wide setting.
+	Version string `mapstructure:"version"`
+	// GroupLabels lists the label names this handler's alerts are expected to
+	// carry for the AlertManager route that groups them (AlertManager's own
+	// "group_by" configuration). Alert checks that every configured name is
+	// present in the labels it sends and reports a diagnostic if one is
+	// missing, since AlertManager can only group alerts by labels that are
+	// actually present on them.
+	GroupLabels []string `mapstructure:"group-labels"`
+	// ResolveTimeout overrides how long AlertManager waits before marking a
+	// firing v2 alert as resolved if no update is received, parsed with
+	// time.ParseDuration (e.g. "1h"). Defaults to firingRetention. Only
+	// affects the endsAt sent for non-OK events on the v2 API.
+	ResolveTimeout string `mapstructure:"resolve-timeout"`
+	// StaticLabels are extra labels added to every alert sent by this handler.
+	StaticLabels map[string]string `mapstructure:"labels"`
+	// StaticAnnotations are extra annotations added to every alert sent by this handler.
+	StaticAnnotations map[string]string `mapstructure:"annotations"`
+
+	// BasicAuthUsername and BasicAuthPassword override the service-wide basic
+	// auth credentials for this handler.
+	BasicAuthUsername string `mapstructure:"basic-auth-username"`
+	BasicAuthPassword string `mapstructure:"basic-auth-password"`
+	// BearerToken and BearerTokenFile override the service-wide bearer token
+	// for this handler. BearerToken takes precedence over BearerTokenFile.
+	BearerToken     string `mapstructure:"bearer-token"`
+	BearerTokenFile string `mapstructure:"bearer-token-file"`
+
+	// FieldFormat controls how event.Data.Fields are rendered as AlertManager
+	// annotations: "string" (default) formats scalars directly and JSON encodes
+	// only slices/maps, while "json" JSON encodes every field to preserve structure.
+	FieldFormat string `mapstructure:"field-format"`
+
+	// LabelTemplates and AnnotationTemplates are Go text/template strings
+	// evaluated against the alert.Event for every alert, keyed by the
+	// resulting label/annotation name. They are parsed once when the handler
+	// is constructed and let TICKscript authors derive AlertManager routing
+	// labels (e.g. severity, alertname, instance) without modifying TICKscript.
+	LabelTemplates      map[string]string `mapstructure:"label-templates"`
+	AnnotationTemplates map[string]string `mapstructure:"annotation-templates"`
+}
+
+// urlList returns the de-duplicated set of AlertManager URLs configured on
+// this handler, combining the deprecated single URL field with URLs.
+func (c HandlerConfig) urlList() []string {
+	return mergeURLs(c.URL, c.URLs)
 }
 
 // handler provides the implementation of the alert.Handler interface for the Foo service.
@@ -142,26 +406,61 @@ type handler struct {
 	s    *Service
 	c    HandlerConfig
 	diag Diagnostic
+
+	labelTemplates      map[string]*template.Template
+	annotationTemplates map[string]*template.Template
 }
 
 func (s *Service) DefaultHandlerConfig() HandlerConfig {
 	return HandlerConfig{
 		URL:         s.config().URL,
+		URLs:        s.config().URLs,
 		RetryFolder: s.config().RetryFolder,
+		Version:     s.config().Version,
 	}
 }
 
 func (s *Service) Handler(c HandlerConfig, ctx ...keyvalue.T) (alert.Handler, error) {
+	switch c.FieldFormat {
+	case "", "string", fieldFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown field-format %q, must be \"string\" or \"json\"", c.FieldFormat)
+	}
+
+	diag := s.diag.WithContext(ctx...)
+
+	labelTemplates, err := parseTemplates(c.LabelTemplates)
+	if err != nil {
+		diag.TemplateError(err, keyvalue.KV("templates", "label-templates"))
+		return nil, err
+	}
+	annotationTemplates, err := parseTemplates(c.AnnotationTemplates)
+	if err != nil {
+		diag.TemplateError(err, keyvalue.KV("templates", "annotation-templates"))
+		return nil, err
+	}
+
 	// return a handler config populated with the default room from the service config.
 	return &handler{
-		s:    s,
-		c:    c,
-		diag: s.diag.WithContext(ctx...),
+		s:                   s,
+		c:                   c,
+		diag:                diag,
+		labelTemplates:      labelTemplates,
+		annotationTemplates: annotationTemplates,
 	}, nil
 }
 
 func (h *handler) Handle(event alert.Event) {
-	if err := h.s.Alert(h.c.URL, h.c.RetryFolder, event); err != nil {
+	c := h.c
+	if len(h.labelTemplates) > 0 || len(h.annotationTemplates) > 0 {
+		data := newTemplateData(event)
+		onError := func(name string, err error) {
+			h.diag.TemplateError(err, keyvalue.KV("template", name))
+		}
+		c.StaticLabels = mergeStringMaps(c.StaticLabels, renderTemplates(h.labelTemplates, data, onError))
+		c.StaticAnnotations = mergeStringMaps(c.StaticAnnotations, renderTemplates(h.annotationTemplates, data, onError))
+	}
+	if err := h.s.Alert(c, event); err != nil {
 		h.diag.Error("failed to handle event to AlertManager", err)
 	}
 }
@@ -185,5 +484,5 @@ func (s *Service) Test(o interface{}) error {
 	if !ok {
 		return fmt.Errorf("unexpected options type %T", options)
 	}
-	return s.Alert(options.URL, options.RetryFolder, alert.Event{})
+	return s.Alert(HandlerConfig{URL: options.URL, RetryFolder: options.RetryFolder}, alert.Event{})
 }
@@ -0,0 +1,173 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/kapacitor/alert"
+	"github.com/influxdata/kapacitor/keyvalue"
+)
+
+func postV2Alert(t *testing.T, hc HandlerConfig) alertManagerEvent {
+	t.Helper()
+
+	var captured []alertManagerEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&captured); err != nil {
+			t.Errorf("failed to decode posted alert: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = server.URL
+	c.Version = "v2"
+	c.RetryFolder = t.TempDir()
+	s := NewService(c, fakeDiagnostic{})
+
+	event := alert.Event{State: alert.EventState{Level: alert.Critical, ID: "id-1"}}
+	if err := s.Alert(hc, event); err != nil {
+		t.Fatalf("Alert failed: %v", err)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("expected one posted alert, got %d", len(captured))
+	}
+	return captured[0]
+}
+
+func TestAlert_V2ResolveTimeoutOverride(t *testing.T) {
+	before := time.Now()
+	got := postV2Alert(t, HandlerConfig{ResolveTimeout: "1h"})
+
+	endsAt, err := time.Parse(time.RFC3339, got.EndsAt)
+	if err != nil {
+		t.Fatalf("failed to parse endsAt: %v", err)
+	}
+	if d := endsAt.Sub(before); d < 55*time.Minute || d > 65*time.Minute {
+		t.Errorf("expected endsAt ~1h out per resolve-timeout override, got %v", d)
+	}
+}
+
+func TestAlert_V2DefaultFiringWindow(t *testing.T) {
+	before := time.Now()
+	got := postV2Alert(t, HandlerConfig{})
+
+	endsAt, err := time.Parse(time.RFC3339, got.EndsAt)
+	if err != nil {
+		t.Fatalf("failed to parse endsAt: %v", err)
+	}
+	if d := endsAt.Sub(before); d < firingRetention-time.Minute || d > firingRetention+time.Minute {
+		t.Errorf("expected endsAt ~firingRetention out by default, got %v", d)
+	}
+}
+
+func TestSendAll_TreatsAny2xxAsSuccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted) // 202, not exactly 200
+	}))
+	defer server.Close()
+
+	retryFolder := t.TempDir()
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = server.URL
+	c.RetryFolder = retryFolder
+	s := NewService(c, fakeDiagnostic{})
+
+	event := alert.Event{State: alert.EventState{ID: "id-2"}}
+	if err := s.Alert(HandlerConfig{}, event); err != nil {
+		t.Fatalf("expected a 202 response to be treated as success, got error: %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(retryFolder)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no spooled retry for a 2xx response, got %d", len(entries))
+	}
+}
+
+func TestPost_ReusesConnections(t *testing.T) {
+	var newConns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = server.URL
+	c.RetryFolder = t.TempDir()
+	s := NewService(c, fakeDiagnostic{})
+
+	for i := 0; i < 3; i++ {
+		statusCode, err := s.post(c, resolvedAuth{}, server.URL, []byte(`[]`))
+		if err != nil || statusCode != http.StatusOK {
+			t.Fatalf("post %d failed: statusCode=%d err=%v", i, statusCode, err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&newConns); got != 1 {
+		t.Errorf("expected 3 sequential posts to reuse one keep-alive connection (body must be drained before Close), got %d new connections", got)
+	}
+}
+
+// capturingDiagnostic records Debug messages so tests can assert on
+// diagnostics that aren't otherwise observable from outside the package.
+type capturingDiagnostic struct {
+	debugMsgs *[]string
+}
+
+func (d capturingDiagnostic) WithContext(ctx ...keyvalue.T) Diagnostic { return d }
+func (d capturingDiagnostic) TemplateError(err error, kv keyvalue.T)   {}
+func (d capturingDiagnostic) Error(msg string, err error)              {}
+func (d capturingDiagnostic) Debug(msg string)                         { *d.debugMsgs = append(*d.debugMsgs, msg) }
+func (d capturingDiagnostic) RetryAttempt(file string, attempt int)    {}
+func (d capturingDiagnostic) RetrySuccess(file string)                 {}
+func (d capturingDiagnostic) RetryDropped(file string, attempts int)   {}
+func (d capturingDiagnostic) RetryQueueDepth(depth int)                {}
+
+func TestAlert_GroupLabelsMissingLogsDiagnostic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewConfig()
+	c.Enabled = true
+	c.URL = server.URL
+	c.RetryFolder = t.TempDir()
+
+	var debugMsgs []string
+	s := NewService(c, capturingDiagnostic{debugMsgs: &debugMsgs})
+
+	event := alert.Event{
+		State: alert.EventState{ID: "id-3"},
+		Data:  alert.EventData{Tags: map[string]string{"region": "us-east"}},
+	}
+	hc := HandlerConfig{GroupLabels: []string{"region", "severity"}}
+	if err := s.Alert(hc, event); err != nil {
+		t.Fatalf("Alert failed: %v", err)
+	}
+
+	if len(debugMsgs) != 1 {
+		t.Fatalf("expected exactly one diagnostic for the missing %q group label, got %d: %v", "severity", len(debugMsgs), debugMsgs)
+	}
+}
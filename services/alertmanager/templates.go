@@ -0,0 +1,89 @@
+package alertmanager
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	"github.com/influxdata/kapacitor/alert"
+	"github.com/pkg/errors"
+)
+
+// templateData is the value Go templates in label-templates/annotation-templates
+// are executed against, giving TICKscript authors access to the fields of the
+// alert event without exposing the whole internal alert.Event type.
+type templateData struct {
+	ID       string
+	Message  string
+	Level    string
+	Tags     map[string]string
+	Fields   map[string]interface{}
+	Time     time.Time
+	Duration time.Duration
+	TaskName string
+}
+
+func newTemplateData(event alert.Event) templateData {
+	return templateData{
+		ID:       event.State.ID,
+		Message:  event.State.Message,
+		Level:    event.State.Level.String(),
+		Tags:     event.Data.Tags,
+		Fields:   event.Data.Fields,
+		Time:     event.State.Time,
+		Duration: event.State.Duration,
+		TaskName: event.Data.TaskName,
+	}
+}
+
+// parseTemplates parses each named Go template once so that per-event
+// rendering only has to execute, not parse.
+func parseTemplates(templates map[string]string) (map[string]*template.Template, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]*template.Template, len(templates))
+	for name, tmplText := range templates {
+		t, err := template.New(name).Parse(tmplText)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse template %q", name)
+		}
+		parsed[name] = t
+	}
+	return parsed, nil
+}
+
+// renderTemplates executes every template against data, skipping (and
+// reporting) any individual template that fails to execute so that one bad
+// template doesn't prevent the rest of the labels/annotations from being sent.
+func renderTemplates(templates map[string]*template.Template, data templateData, onError func(name string, err error)) map[string]string {
+	if len(templates) == 0 {
+		return nil
+	}
+	rendered := make(map[string]string, len(templates))
+	for name, t := range templates {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			onError(name, err)
+			continue
+		}
+		rendered[name] = buf.String()
+	}
+	return rendered
+}
+
+// mergeStringMaps returns a new map containing base overlaid with overlay,
+// with overlay values taking precedence on key collisions.
+func mergeStringMaps(base, overlay map[string]string) map[string]string {
+	if len(base) == 0 && len(overlay) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
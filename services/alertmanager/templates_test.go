@@ -0,0 +1,43 @@
+package alertmanager
+
+import (
+	"testing"
+
+	"github.com/influxdata/kapacitor/alert"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	templates, err := parseTemplates(map[string]string{
+		"alertname": "{{.TaskName}}",
+		"severity":  "{{.Level}}",
+	})
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+
+	event := alert.Event{
+		Data: alert.EventData{TaskName: "cpu_alert"},
+	}
+	data := newTemplateData(event)
+
+	var gotErrors []string
+	rendered := renderTemplates(templates, data, func(name string, err error) {
+		gotErrors = append(gotErrors, name)
+	})
+
+	if len(gotErrors) != 0 {
+		t.Fatalf("unexpected template errors: %v", gotErrors)
+	}
+	if rendered["alertname"] != "cpu_alert" {
+		t.Errorf("got alertname %q, want %q", rendered["alertname"], "cpu_alert")
+	}
+}
+
+func TestParseTemplates_InvalidTemplate(t *testing.T) {
+	_, err := parseTemplates(map[string]string{
+		"bad": "{{.Missing",
+	})
+	if err == nil {
+		t.Fatal("expected parse error for malformed template, got nil")
+	}
+}